@@ -0,0 +1,72 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+// Package config covers the agent-wide configuration consumed by the trace
+// agent's stats pipeline (pkg/trace/stats).
+package config
+
+import "time"
+
+// Orchestrator identifies the container orchestration platform the agent is
+// running under, when known.
+type Orchestrator string
+
+// OrchestratorUnknown is the zero value of Orchestrator: no orchestrator was
+// detected (or none applies, e.g. a bare host).
+const OrchestratorUnknown Orchestrator = ""
+
+// AgentConfig carries the subset of the trace agent's configuration that
+// pkg/trace/stats depends on.
+type AgentConfig struct {
+	// DefaultEnv is the env tag applied to traces that don't carry their own.
+	DefaultEnv string
+	// Hostname is the agent's own hostname, used as a fallback when a trace
+	// doesn't carry a tracer-reported hostname.
+	Hostname string
+	// AgentVersion is the running agent's version, stamped onto stats payloads.
+	AgentVersion string
+
+	// BucketInterval is the duration of a single stats time bucket.
+	BucketInterval time.Duration
+
+	// PeerServiceAggregation enables the (deprecated) peer.service aggregation
+	// dimension. PeerTagsAggregation is its successor; both are honored for
+	// backwards compatibility.
+	PeerServiceAggregation bool
+	// PeerTagsAggregation enables aggregation by the configured PeerTags.
+	PeerTagsAggregation bool
+	// PeerTags lists additional tag keys (beyond the embedded peer_tags.ini
+	// defaults) to aggregate peer.service entities by.
+	PeerTags []string
+	// PeerTagsExtraIniPath, if set, is an on-disk peer tags overlay in the
+	// same format as the embedded peer_tags.ini. The trace agent re-reads it
+	// on SIGHUP and swaps it into the running Concentrator without a restart;
+	// see stats.Concentrator.ReloadPeerTags.
+	PeerTagsExtraIniPath string
+
+	// ComputeStatsBySpanKind enables computing stats for spans that are
+	// eligible by span.kind even when they aren't top-level or measured.
+	ComputeStatsBySpanKind bool
+
+	// FargateOrchestrator identifies the Fargate-style orchestrator the agent
+	// is deployed under, if any.
+	FargateOrchestrator Orchestrator
+	// Features holds the set of enabled feature flags, e.g. "enable_cid_stats".
+	Features map[string]struct{}
+
+	// StatsConcentratorShards is the number of shards Concentrator splits its
+	// bucket aggregation across (apm_config.stats_concentrator_shards in
+	// datadog.yaml). Zero defaults to GOMAXPROCS; see stats.shardCount.
+	StatsConcentratorShards int
+
+	// StatsWriterSpoolDir is the directory BufferedWriter spools stats
+	// payloads to when the downstream is unavailable (apm_config.stats_writer
+	// .spool_dir in datadog.yaml). Empty disables on-disk spooling.
+	StatsWriterSpoolDir string
+	// StatsWriterQueueLen is the capacity of BufferedWriter's in-memory queue
+	// (apm_config.stats_writer.queue_len in datadog.yaml). Zero uses the
+	// package default.
+	StatsWriterQueueLen int
+}
@@ -0,0 +1,349 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package stats
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+
+	pb "github.com/DataDog/datadog-agent/pkg/proto/pbgo/trace"
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/log"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+)
+
+// writerMetricPrefix namespaces every metric BufferedWriter emits.
+const writerMetricPrefix = "datadog.trace_agent.stats.writer."
+
+// spoolRescanInterval is how often run re-scans SpoolDir for segments left
+// behind by a previous replay or spoolOrDrop call, so a backlog built up
+// during an outage eventually drains instead of sitting on disk until the
+// next restart.
+const spoolRescanInterval = 30 * time.Second
+
+// ErrWriter is an optional interface a Writer can implement to report whether
+// a payload actually made it out, so BufferedWriter knows when to retry
+// instead of treating delivery as fire-and-forget.
+type ErrWriter interface {
+	// WriteErr writes the payload, returning an error if delivery failed.
+	WriteErr(*pb.StatsPayload) error
+}
+
+// BufferedWriterConfig configures BufferedWriter's queue, retry, and spool
+// behavior.
+type BufferedWriterConfig struct {
+	// QueueLen is the capacity, in payloads, of the bounded in-memory queue.
+	QueueLen int
+	// MinBackoff and MaxBackoff bound the exponential backoff applied between
+	// delivery retries.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxRetries is the number of retries attempted before a payload is
+	// spooled to disk (or dropped, if spooling is disabled). Zero means retry
+	// forever.
+	MaxRetries int
+	// SpoolDir, if non-empty, is where payloads are written as a WAL when the
+	// queue is full or retries are exhausted, and replayed from on startup and
+	// periodically thereafter (see SpoolRescanInterval).
+	SpoolDir string
+	// SpoolRescanInterval is how often run re-scans SpoolDir for segments a
+	// prior replay or spool couldn't fit onto the queue. Zero uses
+	// spoolRescanInterval.
+	SpoolRescanInterval time.Duration
+}
+
+// DefaultBufferedWriterConfig returns the config Concentrator uses when the
+// caller doesn't configure a spool directory explicitly.
+func DefaultBufferedWriterConfig() BufferedWriterConfig {
+	return BufferedWriterConfig{
+		QueueLen:            64,
+		MinBackoff:          500 * time.Millisecond,
+		MaxBackoff:          30 * time.Second,
+		MaxRetries:          5,
+		SpoolRescanInterval: spoolRescanInterval,
+	}
+}
+
+// rescanInterval returns cfg.SpoolRescanInterval, falling back to the package
+// default when unset.
+func (cfg BufferedWriterConfig) rescanInterval() time.Duration {
+	if cfg.SpoolRescanInterval > 0 {
+		return cfg.SpoolRescanInterval
+	}
+	return spoolRescanInterval
+}
+
+// bufferedWriterConfigFromAgentConfig builds a BufferedWriterConfig from the
+// agent's own configuration, falling back to the package defaults for
+// anything left unset.
+func bufferedWriterConfigFromAgentConfig(conf *config.AgentConfig) BufferedWriterConfig {
+	cfg := DefaultBufferedWriterConfig()
+	cfg.SpoolDir = conf.StatsWriterSpoolDir
+	if conf.StatsWriterQueueLen > 0 {
+		cfg.QueueLen = conf.StatsWriterQueueLen
+	}
+	return cfg
+}
+
+// backoffState implements exponential backoff with full jitter, in the style
+// of dskit's backoff.Backoff: call NextDelay in a retry loop and check
+// Ongoing against the configured retry budget.
+type backoffState struct {
+	cfg        BufferedWriterConfig
+	numRetries int
+}
+
+func newBackoffState(cfg BufferedWriterConfig) *backoffState {
+	return &backoffState{cfg: cfg}
+}
+
+// Ongoing reports whether another retry is allowed.
+func (b *backoffState) Ongoing() bool {
+	return b.cfg.MaxRetries == 0 || b.numRetries < b.cfg.MaxRetries
+}
+
+// NextDelay advances the retry count and returns how long to wait before the
+// next attempt.
+func (b *backoffState) NextDelay() time.Duration {
+	b.numRetries++
+	backoff := b.cfg.MinBackoff << (b.numRetries - 1)
+	if backoff <= 0 || backoff > b.cfg.MaxBackoff {
+		backoff = b.cfg.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff))) // #nosec G404 -- jitter, not security-sensitive
+}
+
+// BufferedWriter wraps a Writer with a bounded queue, retry with exponential
+// backoff, and optional on-disk spooling, so a downstream outage at shutdown
+// doesn't silently drop the final flushed payload.
+type BufferedWriter struct {
+	next   Writer
+	cfg    BufferedWriterConfig
+	statsd statsd.ClientInterface
+
+	queue  chan *pb.StatsPayload
+	exit   chan struct{}
+	exitWG sync.WaitGroup
+
+	// replayMu serializes replaySpool calls (see replaySpool).
+	replayMu sync.Mutex
+}
+
+// NewBufferedWriter wraps next, starts the background delivery loop, and
+// replays any payloads spooled from a previous run. The delivery loop must
+// already be draining w.queue before replay starts: a sustained outage can
+// easily spool more segments than cfg.QueueLen, and replaying into an
+// unread channel would otherwise block forever.
+func NewBufferedWriter(next Writer, cfg BufferedWriterConfig, statsdClient statsd.ClientInterface) *BufferedWriter {
+	w := &BufferedWriter{
+		next:   next,
+		cfg:    cfg,
+		statsd: statsdClient,
+		queue:  make(chan *pb.StatsPayload, cfg.QueueLen),
+		exit:   make(chan struct{}),
+	}
+	w.exitWG.Add(1)
+	go w.run()
+	w.replaySpool()
+	return w
+}
+
+// Write enqueues payload for delivery. If the queue is full, it's spooled to
+// disk immediately (or dropped, if SpoolDir is empty) instead of blocking the
+// caller (typically Concentrator's flush loop).
+func (w *BufferedWriter) Write(payload *pb.StatsPayload) {
+	select {
+	case w.queue <- payload:
+		w.gauge("queue_len", float64(len(w.queue)))
+	default:
+		log.Error("stats writer queue is full, spooling payload to disk")
+		w.spoolOrDrop(payload)
+	}
+}
+
+// Stop drains the queue, delivering (or spooling) whatever is left, then
+// returns. Concentrator calls this from its own Stop so the payload flushed
+// on shutdown isn't lost to a transient outage. Draining makes only a single
+// delivery attempt per payload (see drain); anything that doesn't make it out
+// is left for the next process's startup replay.
+func (w *BufferedWriter) Stop() {
+	close(w.exit)
+	w.exitWG.Wait()
+}
+
+func (w *BufferedWriter) run() {
+	defer w.exitWG.Done()
+	rescan := time.NewTicker(w.cfg.rescanInterval())
+	defer rescan.Stop()
+	for {
+		select {
+		case p := <-w.queue:
+			w.deliver(p)
+		case <-rescan.C:
+			w.replaySpool()
+		case <-w.exit:
+			w.drain()
+			return
+		}
+	}
+}
+
+// drain hands off everything left in the queue without blocking for more.
+// Unlike deliver, it makes only a single delivery attempt per payload and
+// spools on that attempt's failure instead of running the full retry loop:
+// Stop is already waiting on exitWG, so blocking here on backoff for a queue
+// full of payloads during a downstream outage would make shutdown itself
+// hang for minutes instead of handing the backlog to disk immediately.
+func (w *BufferedWriter) drain() {
+	for {
+		select {
+		case p := <-w.queue:
+			if w.send(p) {
+				w.gauge("queue_len", float64(len(w.queue)))
+			} else {
+				w.spoolOrDrop(p)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// deliver retries payload against next until it succeeds or the retry budget
+// is exhausted, at which point it's spooled (or dropped).
+func (w *BufferedWriter) deliver(payload *pb.StatsPayload) {
+	b := newBackoffState(w.cfg)
+	for {
+		if w.send(payload) {
+			w.gauge("queue_len", float64(len(w.queue)))
+			return
+		}
+		if !b.Ongoing() {
+			w.spoolOrDrop(payload)
+			return
+		}
+		w.count("retries", 1)
+		time.Sleep(b.NextDelay())
+	}
+}
+
+// send delivers payload once, reporting success. Writers that don't implement
+// ErrWriter are treated as always succeeding, matching their historical
+// fire-and-forget behavior.
+func (w *BufferedWriter) send(payload *pb.StatsPayload) bool {
+	ew, ok := w.next.(ErrWriter)
+	if !ok {
+		w.next.Write(payload)
+		return true
+	}
+	if err := ew.WriteErr(payload); err != nil {
+		log.Errorf("Error writing stats payload: %v", err)
+		return false
+	}
+	return true
+}
+
+func (w *BufferedWriter) spoolOrDrop(payload *pb.StatsPayload) {
+	if w.cfg.SpoolDir == "" {
+		w.count("dropped", 1)
+		return
+	}
+	if err := w.spool(payload); err != nil {
+		log.Errorf("Failed to spool stats payload, dropping it: %v", err)
+		w.count("dropped", 1)
+	}
+}
+
+// spool persists payload as a ULID-named, fsynced segment file so it survives
+// an agent restart and can be replayed by replaySpool.
+func (w *BufferedWriter) spool(payload *pb.StatsPayload) error {
+	data, err := payload.Marshal()
+	if err != nil {
+		return fmt.Errorf("marshal stats payload: %w", err)
+	}
+	path := filepath.Join(w.cfg.SpoolDir, ulid.Make().String()+".stats")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("create spool segment: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("write spool segment: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("fsync spool segment: %w", err)
+	}
+	w.gauge("spooled_bytes", float64(len(data)))
+	return nil
+}
+
+// replaySpool re-enqueues any payloads spooled by a previous run (or by
+// spoolOrDrop when the queue was full or retries ran out), oldest first (ULIDs
+// sort lexicographically by creation time). Enqueueing is non-blocking: once
+// the queue fills up (run is draining it concurrently, but a sustained outage
+// can easily spool more segments than cfg.QueueLen), remaining segments are
+// left on disk for the next call. NewBufferedWriter invokes replaySpool once
+// at startup and run invokes it again every cfg.rescanInterval(), so a backlog
+// larger than QueueLen still drains fully instead of being stuck on disk until
+// a restart. Calls are serialized by replayMu so the startup replay and a
+// rescan never enqueue the same segment twice.
+func (w *BufferedWriter) replaySpool() {
+	if w.cfg.SpoolDir == "" {
+		return
+	}
+	w.replayMu.Lock()
+	defer w.replayMu.Unlock()
+	entries, err := os.ReadDir(w.cfg.SpoolDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Errorf("Failed to list stats writer spool dir %q: %v", w.cfg.SpoolDir, err)
+		}
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, e := range entries {
+		path := filepath.Join(w.cfg.SpoolDir, e.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Errorf("Failed to read spooled stats payload %q: %v", path, err)
+			continue
+		}
+		payload := &pb.StatsPayload{}
+		if err := payload.Unmarshal(data); err != nil {
+			log.Errorf("Failed to decode spooled stats payload %q, discarding it: %v", path, err)
+			_ = os.Remove(path)
+			continue
+		}
+		select {
+		case w.queue <- payload:
+			_ = os.Remove(path)
+		default:
+			log.Debugf("Stats writer queue full during spool replay, leaving %q on disk for later", path)
+		}
+	}
+}
+
+func (w *BufferedWriter) count(metric string, value int64) {
+	if w.statsd == nil {
+		return
+	}
+	_ = w.statsd.Count(writerMetricPrefix+metric, value, nil, 1)
+}
+
+func (w *BufferedWriter) gauge(metric string, value float64) {
+	if w.statsd == nil {
+		return
+	}
+	_ = w.statsd.Gauge(writerMetricPrefix+metric, value, nil, 1)
+}
@@ -0,0 +1,137 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package stats
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/DataDog/datadog-agent/pkg/proto/pbgo/trace"
+)
+
+func TestBackoffStateOngoing(t *testing.T) {
+	b := newBackoffState(BufferedWriterConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Second, MaxRetries: 3})
+	for i := 0; i < 3; i++ {
+		require.True(t, b.Ongoing())
+		b.NextDelay()
+	}
+	assert.False(t, b.Ongoing(), "retry budget must be exhausted after MaxRetries calls")
+}
+
+func TestBackoffStateUnlimitedRetries(t *testing.T) {
+	b := newBackoffState(BufferedWriterConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	for i := 0; i < 100; i++ {
+		require.True(t, b.Ongoing(), "MaxRetries == 0 means retry forever")
+		b.NextDelay()
+	}
+}
+
+// countingWriter records every payload it receives; err is returned from
+// WriteErr to simulate a flaky downstream.
+type countingWriter struct {
+	mu    sync.Mutex
+	count int
+	err   error
+}
+
+func (w *countingWriter) Write(*pb.StatsPayload) {}
+
+func (w *countingWriter) WriteErr(*pb.StatsPayload) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.count++
+	return w.err
+}
+
+func (w *countingWriter) writes() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+func TestBufferedWriterDeliversAndSpoolsOnFailure(t *testing.T) {
+	spoolDir := t.TempDir()
+	next := &countingWriter{err: assert.AnError}
+	cfg := BufferedWriterConfig{
+		QueueLen:   4,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		MaxRetries: 2,
+		SpoolDir:   spoolDir,
+	}
+	w := NewBufferedWriter(next, cfg, nil)
+	w.Write(&pb.StatsPayload{AgentHostname: "host-a"})
+
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(spoolDir)
+		return err == nil && len(entries) == 1
+	}, time.Second, time.Millisecond, "payload should be spooled after exhausting retries")
+	w.Stop()
+}
+
+func TestBufferedWriterReplaysSpoolOnStartup(t *testing.T) {
+	spoolDir := t.TempDir()
+	next := &countingWriter{}
+	w := NewBufferedWriter(next, BufferedWriterConfig{QueueLen: 4, SpoolDir: spoolDir, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, nil)
+	require.NoError(t, w.spool(&pb.StatsPayload{AgentHostname: "host-b"}))
+	w.Stop()
+
+	next2 := &countingWriter{}
+	w2 := NewBufferedWriter(next2, BufferedWriterConfig{QueueLen: 4, SpoolDir: spoolDir, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, nil)
+	defer w2.Stop()
+
+	require.Eventually(t, func() bool { return next2.writes() == 1 }, time.Second, time.Millisecond, "spooled payload should be replayed on startup")
+}
+
+// TestBufferedWriterReplaysMoreSpoolThanQueueCapacity guards against
+// NewBufferedWriter deadlocking when a sustained prior outage spooled more
+// segments than cfg.QueueLen: replay must not block on a channel send nobody
+// is draining yet, and whatever doesn't fit on the first pass must still get
+// delivered by a later periodic rescan rather than being stuck on disk.
+func TestBufferedWriterReplaysMoreSpoolThanQueueCapacity(t *testing.T) {
+	spoolDir := t.TempDir()
+	const queueLen = 2
+	const segments = queueLen * 5
+
+	seed := NewBufferedWriter(&countingWriter{}, BufferedWriterConfig{QueueLen: queueLen, SpoolDir: spoolDir}, nil)
+	for i := 0; i < segments; i++ {
+		require.NoError(t, seed.spool(&pb.StatsPayload{AgentHostname: "host-c"}))
+	}
+	seed.Stop()
+
+	next := &countingWriter{}
+	cfg := BufferedWriterConfig{
+		QueueLen:            queueLen,
+		SpoolDir:            spoolDir,
+		MinBackoff:          time.Millisecond,
+		MaxBackoff:          time.Millisecond,
+		SpoolRescanInterval: time.Millisecond,
+	}
+	done := make(chan *BufferedWriter, 1)
+	go func() {
+		done <- NewBufferedWriter(next, cfg, nil)
+	}()
+
+	var w *BufferedWriter
+	select {
+	case w = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("NewBufferedWriter deadlocked replaying more spooled segments than QueueLen")
+	}
+	defer w.Stop()
+
+	require.Eventually(t, func() bool { return next.writes() == segments }, 5*time.Second, time.Millisecond,
+		"every spooled segment must eventually be delivered, not stranded on disk forever")
+
+	remaining, err := os.ReadDir(spoolDir)
+	require.NoError(t, err)
+	assert.Empty(t, remaining, "spool dir must be drained once rescan has caught up")
+}
@@ -6,12 +6,18 @@
 package stats
 
 import (
-	_ "embed"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"runtime"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	_ "embed"
+
 	"gopkg.in/ini.v1"
 
 	pb "github.com/DataDog/datadog-agent/pkg/proto/pbgo/trace"
@@ -27,6 +33,10 @@ import (
 // units used by the concentrator.
 const defaultBufferLen = 2
 
+// shardContentionMetric is emitted every time Add has to wait on a shard's
+// mutex instead of acquiring it immediately.
+const shardContentionMetric = "datadog.trace_agent.stats.shard_contention"
+
 // Writer is an interface for something that can Write Stats Payloads
 type Writer interface {
 	// Write this payload
@@ -37,6 +47,9 @@ type Writer interface {
 // https://en.wikipedia.org/wiki/Knelson_concentrator
 // Gets an imperial shitton of traces, and outputs pre-computed data structures
 // allowing to find the gold (stats) amongst the traces.
+//
+// Internally, aggregation is split across a bank of shards so that unrelated
+// PayloadAggregationKeys don't contend on the same mutex; see concentratorShard.
 type Concentrator struct {
 	Writer Writer
 
@@ -44,23 +57,84 @@ type Concentrator struct {
 	bsize int64
 	// Timestamp of the oldest time bucket for which we allow data.
 	// Any ingested stats older than it get added to this bucket.
-	oldestTs int64
+	oldestTs atomic.Int64
 	// bufferLen is the number of 10s stats bucket we keep in memory before flushing them.
 	// It means that we can compute stats only for the last `bufferLen * bsize` and that we
 	// wait such time before flushing the stats.
 	// This only applies to past buckets. Stats buckets in the future are allowed with no restriction.
-	bufferLen              int
-	exit                   chan struct{}
-	exitWG                 sync.WaitGroup
-	buckets                map[int64]*RawBucket // buckets used to aggregate stats per timestamp
-	mu                     sync.Mutex
+	bufferLen int
+	exit      chan struct{}
+	exitWG    sync.WaitGroup
+
+	// bufferedWriter is the BufferedWriter NewConcentrator wraps Writer in,
+	// kept around so Stop can drain it on shutdown.
+	bufferedWriter *BufferedWriter
+
+	// shards is the bank of independently-locked bucket stores that traces are
+	// routed to. Its length is fixed for the lifetime of the Concentrator.
+	shards []*concentratorShard
+
+	// Pathways aggregates Data Streams Monitoring checkpoints found on spans
+	// during addNow. It is nil unless wired in with SetPathwayConcentrator.
+	Pathways *PathwayConcentrator
+
+	// Resolver maps a trace's container to a stable workload identity so
+	// aggregation isn't fragmented by ephemeral containers. It is nil unless
+	// wired in with SetWorkloadResolver.
+	Resolver WorkloadResolver
+
 	agentEnv               string
 	agentHostname          string
 	agentVersion           string
-	peerTagsAggregation    bool     // flag to enable aggregation of peer tags
-	computeStatsBySpanKind bool     // flag to enable computation of stats through checking the span.kind field
-	peerTagKeys            []string // keys for supplementary tags that describe peer.service entities
-	statsd                 statsd.ClientInterface
+	peerTagsAggregation    bool // flag to enable aggregation of peer tags
+	computeStatsBySpanKind bool // flag to enable computation of stats through checking the span.kind field
+
+	// peerTagsMu guards peerTagKeys, which ReloadPeerTags can swap at runtime.
+	// Buckets snapshot peerTagKeys when they're created (see concentratorShard),
+	// so a reload only affects buckets created after the call.
+	peerTagsMu  sync.RWMutex
+	peerTagKeys []string // keys for supplementary tags that describe peer.service entities
+
+	// peerTagsIniPath is an optional on-disk peer tags overlay, re-read by
+	// ReloadPeerTags every time Start's SIGHUP listener fires. Empty means
+	// reload only picks up ad-hoc extraTags (e.g. from remote-config).
+	peerTagsIniPath string
+
+	statsd statsd.ClientInterface
+}
+
+// concentratorShard owns a disjoint slice of the Concentrator's time buckets,
+// guarded by its own mutex. A trace's PayloadAggregationKey always hashes to
+// the same shard, so a given key's RawBucket is only ever touched by one
+// shard's lock, and unrelated keys never contend with each other.
+type concentratorShard struct {
+	mu      sync.Mutex
+	buckets map[int64]*RawBucket // buckets used to aggregate stats per timestamp, scoped to this shard
+
+	// peerTagKeys records, per bucket timestamp, the peer tag key set that was
+	// in effect when that bucket was created. ReloadPeerTags can change
+	// Concentrator.peerTagKeys at any time; buckets keep using the set they
+	// were created with so a single bucket's aggregation stays self-consistent.
+	peerTagKeys map[int64][]string
+}
+
+func newConcentratorShard() *concentratorShard {
+	return &concentratorShard{
+		buckets:     make(map[int64]*RawBucket),
+		peerTagKeys: make(map[int64][]string),
+	}
+}
+
+// lock acquires the shard's mutex, recording contention via statsdClient
+// (which may be nil, e.g. in tests) whenever the lock isn't free.
+func (s *concentratorShard) lock(statsdClient statsd.ClientInterface) {
+	if s.mu.TryLock() {
+		return
+	}
+	if statsdClient != nil {
+		_ = statsdClient.Count(shardContentionMetric, 1, nil, 1)
+	}
+	s.mu.Lock()
 }
 
 //go:embed peer_tags.ini
@@ -102,26 +176,46 @@ func preparePeerTags(tags ...string) []string {
 	return deduped
 }
 
-// NewConcentrator initializes a new concentrator ready to be started
+// shardCount returns the number of Concentrator shards to create: the
+// operator-configured value if set, otherwise GOMAXPROCS so that the shard
+// bank scales with the machine the agent runs on.
+func shardCount(conf *config.AgentConfig) int {
+	if conf.StatsConcentratorShards > 0 {
+		return conf.StatsConcentratorShards
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// NewConcentrator initializes a new concentrator ready to be started. The
+// given writer is wrapped in a BufferedWriter so that a downstream outage
+// doesn't drop the payload flushed on shutdown; see bufferedWriter.
 func NewConcentrator(conf *config.AgentConfig, writer Writer, now time.Time, statsd statsd.ClientInterface) *Concentrator {
 	bsize := conf.BucketInterval.Nanoseconds()
+	n := shardCount(conf)
+	shards := make([]*concentratorShard, n)
+	for i := range shards {
+		shards[i] = newConcentratorShard()
+	}
+	bufferedWriter := NewBufferedWriter(writer, bufferedWriterConfigFromAgentConfig(conf), statsd)
 	c := Concentrator{
-		bsize:   bsize,
-		buckets: make(map[int64]*RawBucket),
-		// At start, only allow stats for the current time bucket. Ensure we don't
-		// override buckets which could have been sent before an Agent restart.
-		oldestTs: alignTs(now.UnixNano(), bsize),
+		bsize:  bsize,
+		shards: shards,
 		// TODO: Move to configuration.
 		bufferLen:              defaultBufferLen,
-		Writer:                 writer,
+		Writer:                 bufferedWriter,
+		bufferedWriter:         bufferedWriter,
 		exit:                   make(chan struct{}),
 		agentEnv:               conf.DefaultEnv,
 		agentHostname:          conf.Hostname,
 		agentVersion:           conf.AgentVersion,
 		peerTagsAggregation:    conf.PeerServiceAggregation || conf.PeerTagsAggregation,
 		computeStatsBySpanKind: conf.ComputeStatsBySpanKind,
+		peerTagsIniPath:        conf.PeerTagsExtraIniPath,
 		statsd:                 statsd,
 	}
+	// At start, only allow stats for the current time bucket. Ensure we don't
+	// override buckets which could have been sent before an Agent restart.
+	c.oldestTs.Store(alignTs(now.UnixNano(), bsize))
 	// NOTE: maintain backwards-compatibility with old peer service flag that will eventually be deprecated.
 	if conf.PeerServiceAggregation || conf.PeerTagsAggregation {
 		c.peerTagKeys = preparePeerTags(append(defaultPeerTags, conf.PeerTags...)...)
@@ -129,7 +223,8 @@ func NewConcentrator(conf *config.AgentConfig, writer Writer, now time.Time, sta
 	return &c
 }
 
-// Start starts the concentrator.
+// Start starts the concentrator, including its SIGHUP listener for
+// ReloadPeerTags (see listenForPeerTagsReload).
 func (c *Concentrator) Start() {
 	c.exitWG.Add(1)
 	go func() {
@@ -137,6 +232,7 @@ func (c *Concentrator) Start() {
 		defer c.exitWG.Done()
 		c.Run()
 	}()
+	c.listenForPeerTagsReload()
 }
 
 // Run runs the main loop of the concentrator goroutine. Traces are received
@@ -160,10 +256,61 @@ func (c *Concentrator) Run() {
 	}
 }
 
-// Stop stops the main Run loop.
+// Stop stops the main Run loop, then drains the BufferedWriter so the final
+// shutdown flush survives a transient outage instead of being dropped.
 func (c *Concentrator) Stop() {
 	close(c.exit)
 	c.exitWG.Wait()
+	c.bufferedWriter.Stop()
+}
+
+// PeerTagKeys returns the peer tag key set currently in effect.
+func (c *Concentrator) PeerTagKeys() []string {
+	c.peerTagsMu.RLock()
+	defer c.peerTagsMu.RUnlock()
+	return c.peerTagKeys
+}
+
+// ReloadPeerTags recomputes the peer tag key set from the embedded
+// peer_tags.ini, an optional operator-supplied overlay (extraIni, e.g. fetched
+// over remote-config or re-read on SIGHUP), and any extraTags, then swaps it
+// in atomically. It's safe to call while the Concentrator is running: buckets
+// that already exist keep aggregating with the key set they were created
+// with (see concentratorShard.peerTagKeys), so only buckets created after
+// this call observe the new keys.
+func (c *Concentrator) ReloadPeerTags(extraTags []string, extraIni io.Reader) error {
+	tags := append([]string{}, defaultPeerTags...)
+	if extraIni != nil {
+		cfg, err := ini.Load(extraIni)
+		if err != nil {
+			return fmt.Errorf("loading peer tags overlay: %w", err)
+		}
+		for _, key := range cfg.Section("dd.apm.peer.tags").Keys() {
+			tags = append(tags, strings.Split(key.Value(), ",")...)
+		}
+	}
+	tags = append(tags, extraTags...)
+	newKeys := preparePeerTags(tags...)
+
+	c.peerTagsMu.Lock()
+	c.peerTagKeys = newKeys
+	c.peerTagsMu.Unlock()
+	return nil
+}
+
+// SetPathwayConcentrator wires a PathwayConcentrator into the Concentrator so
+// that DSM checkpoints found while walking trace spans in addNow are
+// aggregated alongside regular trace stats, without a second pass over the
+// trace.
+func (c *Concentrator) SetPathwayConcentrator(pc *PathwayConcentrator) {
+	c.Pathways = pc
+}
+
+// SetWorkloadResolver wires a WorkloadResolver into the Concentrator so that
+// addNow promotes each trace's container into the stable workload identity
+// the resolver returns, instead of aggregating by raw container ID.
+func (c *Concentrator) SetWorkloadResolver(r WorkloadResolver) {
+	c.Resolver = r
 }
 
 // computeStatsForSpanKind returns true if the span.kind value makes the span eligible for stats computation.
@@ -182,14 +329,22 @@ type Input struct {
 	Traces        []traceutil.ProcessedTrace
 	ContainerID   string
 	ContainerTags []string
+	// CgroupPath is the container's cgroup path, used as a fallback lookup key
+	// for Concentrator.Resolver when ContainerID isn't (yet) known to it.
+	CgroupPath string
 }
 
-// NewStatsInput allocates a stats input for an incoming trace payload
-func NewStatsInput(numChunks int, containerID string, clientComputedStats bool, conf *config.AgentConfig) Input {
+// NewStatsInput allocates a stats input for an incoming trace payload.
+// cgroupPath is passed through to Input.CgroupPath regardless of the
+// container-stats feature gates below: unlike ContainerID, it isn't itself a
+// stats dimension, just a fallback lookup key for Concentrator.Resolver when
+// the resolver can't find the container by ID (e.g. a brand new container
+// workloadmeta hasn't caught up on yet).
+func NewStatsInput(numChunks int, containerID string, cgroupPath string, clientComputedStats bool, conf *config.AgentConfig) Input {
 	if clientComputedStats {
 		return Input{}
 	}
-	in := Input{Traces: make([]traceutil.ProcessedTrace, 0, numChunks)}
+	in := Input{Traces: make([]traceutil.ProcessedTrace, 0, numChunks), CgroupPath: cgroupPath}
 	_, enabledCIDStats := conf.Features["enable_cid_stats"]
 	_, disabledCIDStats := conf.Features["disable_cid_stats"]
 	enableContainers := enabledCIDStats || (conf.FargateOrchestrator != config.OrchestratorUnknown)
@@ -201,18 +356,30 @@ func NewStatsInput(numChunks int, containerID string, clientComputedStats bool,
 	return in
 }
 
+// shardFor returns the shard responsible for aggregating the given key. All
+// spans sharing a PayloadAggregationKey always land on the same shard, so
+// RawBucket lookups for that key never cross shards.
+func (c *Concentrator) shardFor(key PayloadAggregationKey) *concentratorShard {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key.Env))
+	_, _ = h.Write([]byte(key.Hostname))
+	_, _ = h.Write([]byte(key.ContainerID))
+	_, _ = h.Write([]byte(key.Version))
+	_, _ = h.Write([]byte(key.GitCommitSha))
+	_, _ = h.Write([]byte(key.ImageTag))
+	return c.shards[h.Sum64()%uint64(len(c.shards))]
+}
+
 // Add applies the given input to the concentrator.
 func (c *Concentrator) Add(t Input) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
 	for _, trace := range t.Traces {
-		c.addNow(&trace, t.ContainerID, t.ContainerTags)
+		c.addNow(&trace, t.ContainerID, t.ContainerTags, t.CgroupPath)
 	}
 }
 
-// addNow adds the given input into the concentrator.
-// Callers must guard!
-func (c *Concentrator) addNow(pt *traceutil.ProcessedTrace, containerID string, containerTags []string) {
+// addNow adds the given input into the concentrator, routing it to the shard
+// owning its PayloadAggregationKey.
+func (c *Concentrator) addNow(pt *traceutil.ProcessedTrace, containerID string, containerTags []string, cgroupPath string) {
 	hostname := pt.TracerHostname
 	if hostname == "" {
 		hostname = c.agentHostname
@@ -222,15 +389,39 @@ func (c *Concentrator) addNow(pt *traceutil.ProcessedTrace, containerID string,
 		env = c.agentEnv
 	}
 	weight := weight(pt.Root)
+
+	// aggContainerID/tags default to the raw container dimension, but are
+	// promoted to a stable workload identity below when a Resolver is
+	// configured, so aggregation survives the container's own lifetime.
+	aggContainerID, tags := containerID, containerTags
+	if c.Resolver != nil {
+		if identity, workloadTags, ok := c.Resolver.Resolve(containerID, cgroupPath); ok {
+			aggContainerID = identity.String()
+			if len(workloadTags) > 0 {
+				tags = workloadTags
+			}
+		}
+	}
+
 	aggKey := PayloadAggregationKey{
 		Env:          env,
 		Hostname:     hostname,
 		Version:      pt.AppVersion,
-		ContainerID:  containerID,
+		ContainerID:  aggContainerID,
 		GitCommitSha: pt.GitCommitSha,
 		ImageTag:     pt.ImageTag,
 	}
+	shard := c.shardFor(aggKey)
+	oldestTs := c.oldestTs.Load()
+
+	shard.lock(c.statsd)
+	defer shard.mu.Unlock()
 	for _, s := range pt.TraceChunk.Spans {
+		if c.Pathways != nil {
+			if checkpoint, ok := s.Meta[checkpointMetaKey]; ok {
+				c.Pathways.addNow(s, checkpoint)
+			}
+		}
 		isTop := traceutil.HasTopLevel(s)
 		eligibleSpanKind := c.computeStatsBySpanKind && computeStatsForSpanKind(s)
 		if !(isTop || traceutil.IsMeasured(s) || eligibleSpanKind) {
@@ -243,19 +434,20 @@ func (c *Concentrator) addNow(pt *traceutil.ProcessedTrace, containerID string,
 		btime := end - end%c.bsize
 
 		// If too far in the past, count in the oldest-allowed time bucket instead.
-		if btime < c.oldestTs {
-			btime = c.oldestTs
+		if btime < oldestTs {
+			btime = oldestTs
 		}
 
-		b, ok := c.buckets[btime]
+		b, ok := shard.buckets[btime]
 		if !ok {
 			b = NewRawBucket(uint64(btime), uint64(c.bsize))
-			if containerID != "" && len(containerTags) > 0 {
-				b.containerTagsByID[containerID] = containerTags
+			if aggContainerID != "" && len(tags) > 0 {
+				b.containerTagsByID[aggContainerID] = tags
 			}
-			c.buckets[btime] = b
+			shard.buckets[btime] = b
+			shard.peerTagKeys[btime] = c.PeerTagKeys()
 		}
-		b.HandleSpan(s, weight, isTop, pt.TraceChunk.Origin, aggKey, c.peerTagsAggregation, c.peerTagKeys)
+		b.HandleSpan(s, weight, isTop, pt.TraceChunk.Origin, aggKey, c.peerTagsAggregation, shard.peerTagKeys[btime])
 	}
 }
 
@@ -265,40 +457,47 @@ func (c *Concentrator) Flush(force bool) *pb.StatsPayload {
 	return c.flushNow(time.Now().UnixNano(), force)
 }
 
+// flushNow drains expired buckets from every shard and merges them into a
+// single StatsPayload. Since each PayloadAggregationKey only ever lands in
+// one shard, merging across shards is just a concatenation of per-key
+// ClientStatsBuckets, not a real aggregation.
 func (c *Concentrator) flushNow(now int64, force bool) *pb.StatsPayload {
 	m := make(map[PayloadAggregationKey][]*pb.ClientStatsBucket)
 	containerTagsByID := make(map[string][]string)
 
-	c.mu.Lock()
-	for ts, srb := range c.buckets {
-		// Always keep `bufferLen` buckets (default is 2: current + previous one).
-		// This is a trade-off: we accept slightly late traces (clock skew and stuff)
-		// but we delay flushing by at most `bufferLen` buckets.
-		//
-		// This delay might result in not flushing stats payload (data loss)
-		// if the agent stops while the latest buckets aren't old enough to be flushed.
-		// The "force" boolean skips the delay and flushes all buckets, typically on agent shutdown.
-		if !force && ts > now-int64(c.bufferLen)*c.bsize {
-			log.Tracef("Bucket %d is not old enough to be flushed, keeping it", ts)
-			continue
-		}
-		log.Debugf("Flushing bucket %d", ts)
-		for k, b := range srb.Export() {
-			m[k] = append(m[k], b)
-			if ctags, ok := srb.containerTagsByID[k.ContainerID]; ok {
-				containerTagsByID[k.ContainerID] = ctags
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		for ts, srb := range shard.buckets {
+			// Always keep `bufferLen` buckets (default is 2: current + previous one).
+			// This is a trade-off: we accept slightly late traces (clock skew and stuff)
+			// but we delay flushing by at most `bufferLen` buckets.
+			//
+			// This delay might result in not flushing stats payload (data loss)
+			// if the agent stops while the latest buckets aren't old enough to be flushed.
+			// The "force" boolean skips the delay and flushes all buckets, typically on agent shutdown.
+			if !force && ts > now-int64(c.bufferLen)*c.bsize {
+				log.Tracef("Bucket %d is not old enough to be flushed, keeping it", ts)
+				continue
+			}
+			log.Debugf("Flushing bucket %d", ts)
+			for k, b := range srb.Export() {
+				m[k] = append(m[k], b)
+				if ctags, ok := srb.containerTagsByID[k.ContainerID]; ok {
+					containerTagsByID[k.ContainerID] = ctags
+				}
 			}
+			delete(shard.buckets, ts)
+			delete(shard.peerTagKeys, ts)
 		}
-		delete(c.buckets, ts)
+		shard.mu.Unlock()
 	}
 	// After flushing, update the oldest timestamp allowed to prevent having stats for
 	// an already-flushed bucket.
 	newOldestTs := alignTs(now, c.bsize) - int64(c.bufferLen-1)*c.bsize
-	if newOldestTs > c.oldestTs {
+	if oldestTs := c.oldestTs.Load(); newOldestTs > oldestTs {
 		log.Debugf("Update oldestTs to %d", newOldestTs)
-		c.oldestTs = newOldestTs
+		c.oldestTs.Store(newOldestTs)
 	}
-	c.mu.Unlock()
 	sb := make([]*pb.ClientStatsPayload, 0, len(m))
 	for k, s := range m {
 		p := &pb.ClientStatsPayload{
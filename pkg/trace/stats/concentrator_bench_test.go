@@ -0,0 +1,103 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package stats
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/DataDog/datadog-agent/pkg/proto/pbgo/trace"
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+)
+
+// benchTraces builds a mix of processed traces spread across numKeys distinct
+// PayloadAggregationKeys (different services/versions/containers), each
+// carrying spansPerTrace top-level spans. This approximates the variety of
+// keys a production shard bank needs to route.
+func benchTraces(numTraces, spansPerTrace, numKeys int) []traceutil.ProcessedTrace {
+	traces := make([]traceutil.ProcessedTrace, 0, numTraces)
+	for i := 0; i < numTraces; i++ {
+		key := i % numKeys
+		spans := make([]*pb.Span, 0, spansPerTrace)
+		var root *pb.Span
+		for j := 0; j < spansPerTrace; j++ {
+			s := &pb.Span{
+				Service:  fmt.Sprintf("service-%d", key),
+				Name:     "op.name",
+				Resource: "/endpoint",
+				SpanID:   uint64(i*spansPerTrace + j),
+				TraceID:  uint64(i),
+				Start:    time.Now().UnixNano(),
+				Duration: int64(time.Millisecond),
+				Metrics:  map[string]float64{"_top_level": 1},
+			}
+			if j == 0 {
+				root = s
+			}
+			spans = append(spans, s)
+		}
+		traces = append(traces, traceutil.ProcessedTrace{
+			TraceChunk: &pb.TraceChunk{Spans: spans},
+			Root:       root,
+			AppVersion: fmt.Sprintf("v%d", key),
+			TracerEnv:  fmt.Sprintf("env-%d", key),
+		})
+	}
+	return traces
+}
+
+func benchConcentrator(shards int) *Concentrator {
+	conf := &config.AgentConfig{
+		BucketInterval:          10 * time.Second,
+		StatsConcentratorShards: shards,
+	}
+	return NewConcentrator(conf, &testStatsWriter{}, time.Now(), nil)
+}
+
+// testStatsWriter discards flushed payloads; only used to satisfy the Writer
+// interface in benchmarks.
+type testStatsWriter struct{}
+
+func (testStatsWriter) Write(*pb.StatsPayload) {}
+
+// BenchmarkConcentratorAdd measures Add throughput for a realistic span mix
+// (100 distinct services/versions, 10 spans per trace) across shard counts.
+func BenchmarkConcentratorAdd(b *testing.B) {
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := benchConcentrator(shards)
+			traces := benchTraces(1000, 10, 100)
+			b.ResetTimer()
+			b.RunParallel(func(pp *testing.PB) {
+				i := 0
+				for pp.Next() {
+					c.Add(Input{Traces: traces[i%len(traces) : i%len(traces)+1]})
+					i++
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkConcentratorFlush measures Flush cost once buckets are populated
+// across shards.
+func BenchmarkConcentratorFlush(b *testing.B) {
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c := benchConcentrator(shards)
+			traces := benchTraces(1000, 10, 100)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				c.Add(Input{Traces: traces})
+				b.StartTimer()
+				c.Flush(true)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package stats
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/DataDog/datadog-agent/pkg/proto/pbgo/trace"
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/traceutil"
+)
+
+// TestReloadPeerTagsOnlyAffectsNewBuckets verifies that a bucket already
+// created before ReloadPeerTags keeps aggregating with the peer tag keys it
+// started with, while a bucket created afterwards picks up the reloaded set.
+func TestReloadPeerTagsOnlyAffectsNewBuckets(t *testing.T) {
+	bsize := int64(10 * time.Second)
+	now := time.Now()
+	conf := &config.AgentConfig{
+		BucketInterval:          time.Duration(bsize),
+		PeerTagsAggregation:     true,
+		StatsConcentratorShards: 1,
+	}
+	c := NewConcentrator(conf, &testStatsWriter{}, now, nil)
+
+	trace := func(start int64) traceutil.ProcessedTrace {
+		s := &pb.Span{
+			Service: "svc", Start: start, Duration: int64(time.Millisecond),
+			Metrics: map[string]float64{"_top_level": 1},
+		}
+		return traceutil.ProcessedTrace{TraceChunk: &pb.TraceChunk{Spans: []*pb.Span{s}}, Root: s}
+	}
+
+	firstBtime := now.UnixNano() - now.UnixNano()%bsize
+	c.Add(Input{Traces: []traceutil.ProcessedTrace{trace(firstBtime)}})
+
+	shard := c.shardFor(PayloadAggregationKey{})
+	require.Contains(t, shard.peerTagKeys, firstBtime)
+	originalKeys := shard.peerTagKeys[firstBtime]
+
+	require.NoError(t, c.ReloadPeerTags([]string{"extra.tag"}, strings.NewReader("")))
+	assert.Contains(t, c.PeerTagKeys(), "extra.tag")
+
+	secondBtime := firstBtime + bsize
+	c.Add(Input{Traces: []traceutil.ProcessedTrace{trace(secondBtime)}})
+
+	assert.Equal(t, originalKeys, shard.peerTagKeys[firstBtime], "existing bucket must keep its original key set")
+	assert.Equal(t, c.PeerTagKeys(), shard.peerTagKeys[secondBtime], "new bucket must observe the reloaded key set")
+}
+
+// TestReloadPeerTagsFromDisk verifies that reloadPeerTagsFromDisk, the
+// handler SIGHUP delivery drives via listenForPeerTagsReload, picks up the
+// overlay file configured via conf.PeerTagsExtraIniPath.
+func TestReloadPeerTagsFromDisk(t *testing.T) {
+	overlay := filepath.Join(t.TempDir(), "peer_tags.ini")
+	require.NoError(t, os.WriteFile(overlay, []byte("[dd.apm.peer.tags]\nextra1 = from_disk\n"), 0o644))
+
+	conf := &config.AgentConfig{
+		BucketInterval:          time.Duration(10 * time.Second),
+		StatsConcentratorShards: 1,
+		PeerTagsExtraIniPath:    overlay,
+	}
+	c := NewConcentrator(conf, &testStatsWriter{}, time.Now(), nil)
+
+	require.NoError(t, c.reloadPeerTagsFromDisk())
+	assert.Contains(t, c.PeerTagKeys(), "from_disk")
+}
+
+// TestReloadPeerTagsFromDiskNoOverlay verifies that a Concentrator with no
+// overlay configured still reloads cleanly (matching default PeerTags).
+func TestReloadPeerTagsFromDiskNoOverlay(t *testing.T) {
+	conf := &config.AgentConfig{
+		BucketInterval:          time.Duration(10 * time.Second),
+		StatsConcentratorShards: 1,
+	}
+	c := NewConcentrator(conf, &testStatsWriter{}, time.Now(), nil)
+
+	require.NoError(t, c.reloadPeerTagsFromDisk())
+}
@@ -0,0 +1,245 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package stats
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DataDog/sketches-go/ddsketch"
+
+	pb "github.com/DataDog/datadog-agent/pkg/proto/pbgo/trace"
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+	"github.com/DataDog/datadog-agent/pkg/trace/log"
+	"github.com/DataDog/datadog-agent/pkg/trace/watchdog"
+
+	"github.com/DataDog/datadog-go/v5/statsd"
+)
+
+// checkpointMetaKey is the span meta key tracers set on spans that represent a
+// Data Streams Monitoring pathway checkpoint (produce/consume edge), mirroring
+// the checkpoint model used by dd-trace-go's datastreams package.
+const checkpointMetaKey = "_dd.dsm.checkpoint"
+
+// checkpointSketchAccuracy is the relative accuracy used for the pathway
+// latency histograms, matching the value the backend expects for DSM buckets.
+const checkpointSketchAccuracy = 0.01
+
+// PathwayWriter is an interface for something that can write Data Streams
+// Monitoring payloads. It is kept separate from Writer so that trace stats and
+// DSM stats can be shipped to different intake endpoints on independent
+// schedules.
+type PathwayWriter interface {
+	WritePathwayStats(*pb.DataStreamsPayload)
+}
+
+// pathwayAggKey groups pathway checkpoints that belong in the same latency
+// histogram: same service, same edge, same position in the pathway DAG.
+type pathwayAggKey struct {
+	service    string
+	edgeTags   string // edge tags (e.g. "direction:out,topic:X,type:kafka"), joined so the key is comparable
+	hash       uint64
+	parentHash uint64
+}
+
+// pathwayBucket holds the DDSketch latency histograms for every pathway key
+// observed during one time bucket.
+type pathwayBucket struct {
+	start, duration uint64
+	sketches        map[pathwayAggKey]*ddsketch.DDSketch
+}
+
+func newPathwayBucket(ts, bsize uint64) *pathwayBucket {
+	return &pathwayBucket{start: ts, duration: bsize, sketches: make(map[pathwayAggKey]*ddsketch.DDSketch)}
+}
+
+func (b *pathwayBucket) add(key pathwayAggKey, latencySeconds float64) {
+	s, ok := b.sketches[key]
+	if !ok {
+		var err error
+		s, err = ddsketch.NewDefaultDDSketch(checkpointSketchAccuracy)
+		if err != nil {
+			log.Errorf("Error creating DDSketch for pathway %+v: %v", key, err)
+			return
+		}
+		b.sketches[key] = s
+	}
+	if err := s.Add(latencySeconds); err != nil {
+		log.Debugf("Error adding latency to pathway sketch: %v", err)
+	}
+}
+
+func (b *pathwayBucket) export() []*pb.DataStreamsBucket {
+	stats := make([]*pb.DataStreamsBucket, 0, len(b.sketches))
+	for k, sketch := range b.sketches {
+		summary, err := sketch.ToProto().Marshal()
+		if err != nil {
+			log.Errorf("Error marshalling pathway sketch for %+v: %v", k, err)
+			continue
+		}
+		stats = append(stats, &pb.DataStreamsBucket{
+			Start:         b.start,
+			Duration:      b.duration,
+			Service:       k.service,
+			EdgeTags:      strings.Split(k.edgeTags, ","),
+			Hash:          k.hash,
+			ParentHash:    k.parentHash,
+			LatencySketch: summary,
+		})
+	}
+	return stats
+}
+
+// PathwayConcentrator aggregates Data Streams Monitoring pathway checkpoints
+// into DDSketch-backed latency histograms, using the same time-bucket
+// alignment and buffered flush semantics as Concentrator.
+type PathwayConcentrator struct {
+	Writer PathwayWriter
+
+	bsize     int64
+	bufferLen int
+
+	mu       sync.Mutex
+	buckets  map[int64]*pathwayBucket
+	oldestTs int64
+
+	exit   chan struct{}
+	exitWG sync.WaitGroup
+	statsd statsd.ClientInterface
+}
+
+// NewPathwayConcentrator initializes a new PathwayConcentrator ready to be
+// started. It is constructed separately from Concentrator and wired in with
+// SetPathwayConcentrator, since not every agent configuration ingests DSM
+// traffic.
+func NewPathwayConcentrator(conf *config.AgentConfig, writer PathwayWriter, now time.Time, statsd statsd.ClientInterface) *PathwayConcentrator {
+	bsize := conf.BucketInterval.Nanoseconds()
+	return &PathwayConcentrator{
+		Writer:    writer,
+		bsize:     bsize,
+		bufferLen: defaultBufferLen,
+		buckets:   make(map[int64]*pathwayBucket),
+		oldestTs:  alignTs(now.UnixNano(), bsize),
+		exit:      make(chan struct{}),
+		statsd:    statsd,
+	}
+}
+
+// Start starts the pathway concentrator's flush loop.
+func (c *PathwayConcentrator) Start() {
+	c.exitWG.Add(1)
+	go func() {
+		defer watchdog.LogOnPanic(c.statsd)
+		defer c.exitWG.Done()
+		c.Run()
+	}()
+}
+
+// Run runs the main flush loop of the pathway concentrator.
+func (c *PathwayConcentrator) Run() {
+	flushTicker := time.NewTicker(time.Duration(c.bsize) * time.Nanosecond)
+	defer flushTicker.Stop()
+
+	log.Debug("Starting pathway concentrator")
+
+	for {
+		select {
+		case <-flushTicker.C:
+			c.Writer.WritePathwayStats(c.Flush(false))
+		case <-c.exit:
+			log.Info("Exiting pathway concentrator, computing remaining stats")
+			c.Writer.WritePathwayStats(c.Flush(true))
+			return
+		}
+	}
+}
+
+// Stop stops the main Run loop.
+func (c *PathwayConcentrator) Stop() {
+	close(c.exit)
+	c.exitWG.Wait()
+}
+
+// addNow records a single pathway checkpoint read off of a span's
+// "_dd.dsm.checkpoint" meta. It is called from Concentrator.addNow as part of
+// its existing pass over the trace's spans, so ingesting DSM traffic costs no
+// additional walk of the trace.
+func (c *PathwayConcentrator) addNow(s *pb.Span, checkpoint string) {
+	key, latency, ok := parseCheckpoint(s, checkpoint)
+	if !ok {
+		return
+	}
+	btime := alignTs(s.Start, c.bsize)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if btime < c.oldestTs {
+		btime = c.oldestTs
+	}
+	b, ok := c.buckets[btime]
+	if !ok {
+		b = newPathwayBucket(uint64(btime), uint64(c.bsize))
+		c.buckets[btime] = b
+	}
+	b.add(key, latency)
+}
+
+// parseCheckpoint decodes the checkpoint metadata a tracer attaches to a span.
+// The checkpoint is a pipe-separated "parentHash|hash|edgeTag1,edgeTag2,..."
+// triple. Pathway latency isn't computed from the span's own timing: tracers
+// already measure it end to end against the time the pathway was first
+// produced and stamp the result, in nanoseconds, onto the span's
+// "_dd.dsm.pathway_latency_ns" metric, which is read directly here.
+func parseCheckpoint(s *pb.Span, checkpoint string) (pathwayAggKey, float64, bool) {
+	parts := strings.SplitN(checkpoint, "|", 3)
+	if len(parts) != 3 {
+		log.Debugf("Malformed DSM checkpoint %q on span %d, dropping", checkpoint, s.SpanID)
+		return pathwayAggKey{}, 0, false
+	}
+	parentHash, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return pathwayAggKey{}, 0, false
+	}
+	hash, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return pathwayAggKey{}, 0, false
+	}
+	key := pathwayAggKey{
+		service:    s.Service,
+		edgeTags:   parts[2],
+		hash:       hash,
+		parentHash: parentHash,
+	}
+	latencyNs := s.Metrics["_dd.dsm.pathway_latency_ns"]
+	return key, latencyNs / float64(time.Second), true
+}
+
+// Flush deletes and returns complete pathway buckets as a DataStreamsPayload.
+// The force boolean guarantees flushing all buckets if set to true.
+func (c *PathwayConcentrator) Flush(force bool) *pb.DataStreamsPayload {
+	return c.flushNow(time.Now().UnixNano(), force)
+}
+
+func (c *PathwayConcentrator) flushNow(now int64, force bool) *pb.DataStreamsPayload {
+	c.mu.Lock()
+	var stats []*pb.DataStreamsBucket
+	for ts, b := range c.buckets {
+		if !force && ts > now-int64(c.bufferLen)*c.bsize {
+			continue
+		}
+		stats = append(stats, b.export()...)
+		delete(c.buckets, ts)
+	}
+	newOldestTs := alignTs(now, c.bsize) - int64(c.bufferLen-1)*c.bsize
+	if newOldestTs > c.oldestTs {
+		c.oldestTs = newOldestTs
+	}
+	c.mu.Unlock()
+
+	return &pb.DataStreamsPayload{Stats: stats}
+}
@@ -0,0 +1,118 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pb "github.com/DataDog/datadog-agent/pkg/proto/pbgo/trace"
+	"github.com/DataDog/datadog-agent/pkg/trace/config"
+)
+
+func TestParseCheckpoint(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		checkpoint string
+		wantOK     bool
+	}{
+		{name: "valid", checkpoint: "1|2|direction:out,topic:X,type:kafka", wantOK: true},
+		{name: "missing edge tags segment", checkpoint: "1|2", wantOK: false},
+		{name: "non-numeric parent hash", checkpoint: "nope|2|direction:out", wantOK: false},
+		{name: "non-numeric hash", checkpoint: "1|nope|direction:out", wantOK: false},
+		{name: "empty", checkpoint: "", wantOK: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &pb.Span{
+				Service: "checkout",
+				Metrics: map[string]float64{"_dd.dsm.pathway_latency_ns": float64(2 * time.Second)},
+			}
+			key, latency, ok := parseCheckpoint(s, tt.checkpoint)
+			require.Equal(t, tt.wantOK, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, pathwayAggKey{service: "checkout", edgeTags: "direction:out,topic:X,type:kafka", hash: 2, parentHash: 1}, key)
+			assert.InDelta(t, 2.0, latency, 0.0001, "latency must come from _dd.dsm.pathway_latency_ns, not span timing")
+		})
+	}
+}
+
+func TestPathwayBucketAddAndExport(t *testing.T) {
+	b := newPathwayBucket(1000, 10)
+	key := pathwayAggKey{service: "checkout", edgeTags: "direction:out,topic:X", hash: 2, parentHash: 1}
+
+	b.add(key, 0.1)
+	b.add(key, 0.2)
+
+	stats := b.export()
+	require.Len(t, stats, 1)
+	assert.Equal(t, uint64(1000), stats[0].Start)
+	assert.Equal(t, uint64(10), stats[0].Duration)
+	assert.Equal(t, "checkout", stats[0].Service)
+	assert.Equal(t, []string{"direction:out", "topic:X"}, stats[0].EdgeTags)
+	assert.Equal(t, uint64(2), stats[0].Hash)
+	assert.Equal(t, uint64(1), stats[0].ParentHash)
+	assert.NotEmpty(t, stats[0].LatencySketch, "sketch summary must be marshalled")
+}
+
+func TestPathwayBucketExportIsolatesKeys(t *testing.T) {
+	b := newPathwayBucket(0, 10)
+	b.add(pathwayAggKey{service: "a"}, 0.1)
+	b.add(pathwayAggKey{service: "b"}, 0.2)
+
+	assert.Len(t, b.export(), 2)
+}
+
+func newPathwayConcentrator(t *testing.T, bsize time.Duration) (*PathwayConcentrator, *countingPathwayWriter) {
+	t.Helper()
+	conf := &config.AgentConfig{BucketInterval: bsize}
+	w := &countingPathwayWriter{}
+	return NewPathwayConcentrator(conf, w, time.Now(), nil), w
+}
+
+type countingPathwayWriter struct {
+	payloads []*pb.DataStreamsPayload
+}
+
+func (w *countingPathwayWriter) WritePathwayStats(p *pb.DataStreamsPayload) {
+	w.payloads = append(w.payloads, p)
+}
+
+func TestPathwayConcentratorFlushBuffering(t *testing.T) {
+	bsize := 10 * time.Second
+	c, _ := newPathwayConcentrator(t, bsize)
+
+	now := time.Now()
+	s := &pb.Span{Service: "checkout", Start: now.UnixNano(), Metrics: map[string]float64{"_dd.dsm.pathway_latency_ns": 1e9}}
+	c.addNow(s, "1|2|direction:out")
+
+	// Within bufferLen buckets of "now": a non-forced flush must not export it
+	// yet, matching Concentrator's own buffered-flush semantics.
+	payload := c.flushNow(now.UnixNano(), false)
+	assert.Empty(t, payload.Stats, "bucket should still be buffered")
+
+	payload = c.flushNow(now.UnixNano(), true)
+	require.Len(t, payload.Stats, 1)
+	assert.Equal(t, "checkout", payload.Stats[0].Service)
+
+	// The bucket was deleted by the forced flush, so a second force-flush has
+	// nothing left to export.
+	payload = c.flushNow(now.UnixNano(), true)
+	assert.Empty(t, payload.Stats)
+}
+
+func TestPathwayConcentratorAddNowIgnoresMalformedCheckpoint(t *testing.T) {
+	c, _ := newPathwayConcentrator(t, 10*time.Second)
+	s := &pb.Span{Service: "checkout", Start: time.Now().UnixNano()}
+	c.addNow(s, "not-a-valid-checkpoint")
+
+	payload := c.Flush(true)
+	assert.Empty(t, payload.Stats, "malformed checkpoints must not create a bucket")
+}
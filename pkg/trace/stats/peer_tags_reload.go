@@ -0,0 +1,36 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package stats
+
+import (
+	"bytes"
+	"os"
+)
+
+// reloadPeerTagsFromDisk re-reads peerTagsIniPath, if configured, and swaps
+// it in via ReloadPeerTags. Called by listenForPeerTagsReload on SIGHUP.
+func (c *Concentrator) reloadPeerTagsFromDisk() error {
+	if c.peerTagsIniPath == "" {
+		return c.ReloadPeerTags(nil, nil)
+	}
+	f, err := os.Open(c.peerTagsIniPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.ReloadPeerTags(nil, f)
+}
+
+// OnRemoteConfigPeerTagsUpdate is the callback to register with the agent's
+// remote-config client for the peer tags product (APM_TRACING / peer_tags
+// overlay): it forwards the product's payload straight into ReloadPeerTags
+// so a fleet-wide config push takes effect without a restart.
+func (c *Concentrator) OnRemoteConfigPeerTagsUpdate(extraTags []string, extraIni []byte) error {
+	if len(extraIni) == 0 {
+		return c.ReloadPeerTags(extraTags, nil)
+	}
+	return c.ReloadPeerTags(extraTags, bytes.NewReader(extraIni))
+}
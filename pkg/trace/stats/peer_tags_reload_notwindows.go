@@ -0,0 +1,41 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build !windows
+
+package stats
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/DataDog/datadog-agent/pkg/trace/log"
+)
+
+// listenForPeerTagsReload starts a goroutine that calls reloadPeerTagsFromDisk
+// every time the process receives SIGHUP. This is the operator-facing half of
+// ReloadPeerTags: a fleet can roll out new peer-tag dimensions by updating the
+// overlay file on disk and sending SIGHUP, with no agent restart.
+func (c *Concentrator) listenForPeerTagsReload() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+
+	c.exitWG.Add(1)
+	go func() {
+		defer c.exitWG.Done()
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-sig:
+				if err := c.reloadPeerTagsFromDisk(); err != nil {
+					log.Errorf("Failed to reload peer tags on SIGHUP: %v", err)
+				}
+			case <-c.exit:
+				return
+			}
+		}
+	}()
+}
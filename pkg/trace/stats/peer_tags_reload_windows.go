@@ -0,0 +1,13 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+//go:build windows
+
+package stats
+
+// listenForPeerTagsReload is a no-op on Windows: there is no SIGHUP
+// equivalent to hang a reload trigger off of. Windows deployments can still
+// pick up peer tag changes via OnRemoteConfigPeerTagsUpdate.
+func (c *Concentrator) listenForPeerTagsReload() {}
@@ -0,0 +1,165 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package stats
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/DataDog/datadog-agent/pkg/workloadmeta"
+)
+
+// WorkloadIdentity is the stable identity of the workload a container belongs
+// to (a pod, an ECS task, a Kubernetes deployment, ...), as opposed to the
+// container itself, which may be short-lived.
+type WorkloadIdentity struct {
+	Kind string // e.g. "pod", "ecs_task", "deployment"
+	ID   string
+}
+
+// String returns a key suitable for use as a PayloadAggregationKey.ContainerID
+// so resolved workloads still fit the existing aggregation/export path.
+func (w WorkloadIdentity) String() string {
+	if w.Kind == "" && w.ID == "" {
+		return ""
+	}
+	return w.Kind + ":" + w.ID
+}
+
+// WorkloadResolver maps a container to the stable workload it belongs to, so
+// stats aggregate over the workload's lifetime rather than fragmenting across
+// its ephemeral containers.
+type WorkloadResolver interface {
+	// Resolve looks up the workload owning containerID. If containerID is
+	// unknown (e.g. not yet reflected in workloadmeta) but cgroupPath is set,
+	// it falls back to a longest-prefix match over known cgroup paths. The
+	// returned tags are the container/pod tags to attach to the aggregated
+	// payload for this workload.
+	Resolve(containerID, cgroupPath string) (identity WorkloadIdentity, tags []string, ok bool)
+}
+
+// cgroupTrieNode is one path segment of a cgroupTrie.
+type cgroupTrieNode struct {
+	children map[string]*cgroupTrieNode
+	identity WorkloadIdentity
+	tags     []string
+	isLeaf   bool
+}
+
+// cgroupTrie supports fast longest-prefix lookup of a workload identity from
+// a cgroup path, which is the only thing available for nested/Fargate cgroup
+// layouts where the container ID isn't present in the path itself.
+type cgroupTrie struct {
+	mu   sync.RWMutex
+	root *cgroupTrieNode
+}
+
+func newCgroupTrie() *cgroupTrie {
+	return &cgroupTrie{root: &cgroupTrieNode{children: make(map[string]*cgroupTrieNode)}}
+}
+
+func splitCgroupPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// Insert records the workload owning the cgroup subtree rooted at path.
+func (t *cgroupTrie) Insert(path string, identity WorkloadIdentity, tags []string) {
+	if path == "" {
+		return
+	}
+	segments := splitCgroupPath(path)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	node := t.root
+	for _, seg := range segments {
+		child, ok := node.children[seg]
+		if !ok {
+			child = &cgroupTrieNode{children: make(map[string]*cgroupTrieNode)}
+			node.children[seg] = child
+		}
+		node = child
+	}
+	node.identity = identity
+	node.tags = tags
+	node.isLeaf = true
+}
+
+// LongestPrefixMatch returns the workload registered at the deepest ancestor
+// of path, if any.
+func (t *cgroupTrie) LongestPrefixMatch(path string) (WorkloadIdentity, []string, bool) {
+	if path == "" {
+		return WorkloadIdentity{}, nil, false
+	}
+	segments := splitCgroupPath(path)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	node := t.root
+	var (
+		bestIdentity WorkloadIdentity
+		bestTags     []string
+		found        bool
+	)
+	for _, seg := range segments {
+		child, ok := node.children[seg]
+		if !ok {
+			break
+		}
+		node = child
+		if node.isLeaf {
+			bestIdentity, bestTags, found = node.identity, node.tags, true
+		}
+	}
+	return bestIdentity, bestTags, found
+}
+
+// WorkloadMetaResolver implements WorkloadResolver against workloadmeta,
+// resolving containers to the pod/ECS task/deployment that owns them and
+// falling back to a cgroup-path trie lookup when the container isn't
+// (yet) known by ID.
+type WorkloadMetaResolver struct {
+	store workloadmeta.Component
+	trie  *cgroupTrie
+}
+
+// NewWorkloadMetaResolver returns a WorkloadResolver backed by the given
+// workloadmeta store.
+func NewWorkloadMetaResolver(store workloadmeta.Component) *WorkloadMetaResolver {
+	return &WorkloadMetaResolver{store: store, trie: newCgroupTrie()}
+}
+
+// Resolve implements WorkloadResolver.
+func (r *WorkloadMetaResolver) Resolve(containerID, cgroupPath string) (WorkloadIdentity, []string, bool) {
+	if containerID != "" {
+		if identity, tags, ok := r.resolveByContainerID(containerID); ok {
+			if cgroupPath != "" {
+				r.trie.Insert(cgroupPath, identity, tags)
+			}
+			return identity, tags, true
+		}
+	}
+	if cgroupPath != "" {
+		return r.trie.LongestPrefixMatch(cgroupPath)
+	}
+	return WorkloadIdentity{}, nil, false
+}
+
+// resolveByContainerID looks the container up in workloadmeta and derives its
+// owning workload identity from the entity's pod/task/deployment ownership.
+// A container with no such owner (a standalone, non-orchestrated container)
+// reports ok=false so the caller keeps aggregating under the real container
+// ID instead of a synthesized identity that would silently change
+// ClientStatsPayload.ContainerID's format for that case.
+func (r *WorkloadMetaResolver) resolveByContainerID(containerID string) (WorkloadIdentity, []string, bool) {
+	entity, err := r.store.GetContainer(containerID)
+	if err != nil {
+		return WorkloadIdentity{}, nil, false
+	}
+	owner := entity.Owner
+	if owner == nil {
+		return WorkloadIdentity{}, nil, false
+	}
+	return WorkloadIdentity{Kind: string(owner.Kind), ID: owner.ID}, entity.EntityMeta.StandardTags, true
+}
@@ -0,0 +1,75 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package stats
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DataDog/datadog-agent/pkg/workloadmeta"
+)
+
+func TestCgroupTrieLongestPrefixMatch(t *testing.T) {
+	trie := newCgroupTrie()
+	pod := WorkloadIdentity{Kind: "pod", ID: "pod-abc"}
+	trie.Insert("/kubepods/burstable/podabc/containerxyz", pod, []string{"pod_name:my-pod"})
+
+	id, tags, ok := trie.LongestPrefixMatch("/kubepods/burstable/podabc/containerxyz/nested")
+	assert.True(t, ok)
+	assert.Equal(t, pod, id)
+	assert.Equal(t, []string{"pod_name:my-pod"}, tags)
+
+	_, _, ok = trie.LongestPrefixMatch("/kubepods/burstable/poddef/containerxyz")
+	assert.False(t, ok, "unrelated path must not match")
+
+	_, _, ok = trie.LongestPrefixMatch("")
+	assert.False(t, ok)
+}
+
+func TestWorkloadIdentityString(t *testing.T) {
+	assert.Equal(t, "", WorkloadIdentity{}.String())
+	assert.Equal(t, "pod:abc", WorkloadIdentity{Kind: "pod", ID: "abc"}.String())
+}
+
+// stubWorkloadmetaStore is a fake workloadmeta.Component backed by an
+// in-memory map, for exercising WorkloadMetaResolver without a real store.
+type stubWorkloadmetaStore struct {
+	containers map[string]workloadmeta.Container
+}
+
+func (s *stubWorkloadmetaStore) GetContainer(id string) (workloadmeta.Container, error) {
+	c, ok := s.containers[id]
+	if !ok {
+		return workloadmeta.Container{}, errors.New("container not found")
+	}
+	return c, nil
+}
+
+func TestWorkloadMetaResolverResolveByContainerID(t *testing.T) {
+	store := &stubWorkloadmetaStore{containers: map[string]workloadmeta.Container{
+		"owned": {
+			Owner:      &workloadmeta.EntityID{Kind: workloadmeta.KindKubernetesPod, ID: "pod-abc"},
+			EntityMeta: workloadmeta.EntityMeta{StandardTags: []string{"pod_name:my-pod"}},
+		},
+		"standalone": {
+			Owner: nil,
+		},
+	}}
+	r := NewWorkloadMetaResolver(store)
+
+	identity, tags, ok := r.Resolve("owned", "")
+	assert.True(t, ok, "a container with an owner must resolve")
+	assert.Equal(t, WorkloadIdentity{Kind: "kubernetes_pod", ID: "pod-abc"}, identity)
+	assert.Equal(t, []string{"pod_name:my-pod"}, tags)
+
+	_, _, ok = r.Resolve("standalone", "")
+	assert.False(t, ok, "an ownerless (standalone) container must not get a synthesized identity")
+
+	_, _, ok = r.Resolve("unknown", "")
+	assert.False(t, ok, "a container missing from the store must not resolve")
+}
@@ -0,0 +1,50 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+// Package workloadmeta covers the subset of the agent-wide workloadmeta store
+// consumed by pkg/trace/stats: looking a container up by ID and reading the
+// workload (pod, ECS task, deployment, ...) that owns it.
+package workloadmeta
+
+// Kind identifies the type of entity an EntityID refers to.
+type Kind string
+
+// Kinds of entities pkg/trace/stats resolves containers up to.
+const (
+	KindContainer     Kind = "container"
+	KindKubernetesPod Kind = "kubernetes_pod"
+	KindECSTask       Kind = "ecs_task"
+)
+
+// EntityID uniquely identifies an entity tracked by workloadmeta.
+type EntityID struct {
+	Kind Kind
+	ID   string
+}
+
+// EntityMeta carries the descriptive metadata workloadmeta collects for an
+// entity, independent of its kind.
+type EntityMeta struct {
+	Name string
+	// StandardTags are the Datadog standard tags (env/service/version and
+	// similar) workloadmeta derives for the entity, e.g. from pod labels.
+	StandardTags []string
+}
+
+// Container is a container entity as tracked by workloadmeta.
+type Container struct {
+	EntityID
+	EntityMeta EntityMeta
+	// Owner is the workload (pod, ECS task, ...) this container belongs to,
+	// or nil for a standalone container with no such owner.
+	Owner *EntityID
+}
+
+// Component is the part of the workloadmeta store pkg/trace/stats depends on.
+type Component interface {
+	// GetContainer returns the container entity for id, or an error if it
+	// isn't (yet) known to the store.
+	GetContainer(id string) (Container, error)
+}